@@ -0,0 +1,552 @@
+// s3repo
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexlukichev/s3repo/pkg/s3repo"
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("s3repo")
+
+// Example format string. Everything except the message has a custom color
+// which is dependent on the log level. Many fields have a custom output
+// formatting too, eg. the time returns the hour down to the milli second.
+var format = logging.MustStringFormatter(
+	"%{color}%{time:15:04:05.000} %{shortfunc} â–¶ %{level:.4s} %{id:03x}%{color:reset} %{message}",
+)
+
+var region = flag.String("z", "us-east-1", "AWS region")
+var bucket = flag.String("b", "", "bucket to query")
+var service = flag.String("s", "", "service component to update")
+var prefix = flag.String("r", "0.1.", "version prefix to match (DEPRECATED; ignored when used with -w)")
+var pattern = flag.String("w", "", "version pattern to match")
+var destination = flag.String("d", "", "destination directory")
+var showName = flag.Bool("p", false, "display the name of the downloaded file")
+var showProgress = flag.Bool("i", false, "display progress")
+var storeName = flag.String("n", "", "store the name of the downloaded file in the specified location")
+var debug = flag.Bool("v", false, "verbose output")
+var versions = flag.Bool("V", false, "list/update against all object versions, including deleted ones")
+var versionID = flag.String("version-id", "", "on update, fetch this specific VersionId instead of the latest match; on promote, pins -key to this VersionId")
+var maxKeys = flag.Int64("max-keys", 0, "stop scanning after this many keys have been seen (0 = unlimited)")
+var since = flag.Duration("since", 0, "only consider objects last modified within this duration (0 = no limit)")
+var endpoint = flag.String("endpoint", "", "S3-compatible endpoint URL (MinIO, Ceph RGW, LocalStack, ...); empty uses AWS")
+var pathStyle = flag.Bool("path-style", false, "use path-style bucket addressing, as required by most non-AWS endpoints")
+var noVerifySSL = flag.Bool("no-verify-ssl", false, "skip TLS certificate verification when talking to -endpoint")
+var awsProfile = flag.String("profile", "", "AWS credentials profile to use instead of environment credentials")
+var partSize = flag.Int64("part-size", 0, "part size in bytes for concurrent multipart downloads (0 = SDK default)")
+var concurrency = flag.Int("concurrency", 0, "number of concurrent parts to download (0 = SDK default)")
+var verify = flag.String("verify", "etag", "post-download integrity check: etag|sha256|both|none")
+var channel = flag.String("channel", "", "promote: release channel to tag/manifest as; list/update: resolve via the <service>/<channel>.json manifest instead of scanning")
+var promoteKey = flag.String("key", "", "promote: explicit object key to promote instead of resolving the highest match")
+var writeManifest = flag.Bool("manifest", true, "promote: write the <service>/<channel>.json release manifest")
+var tagObject = flag.Bool("tag", true, "promote: set the s3repo-channel=<channel> object tag")
+var extract = flag.String("extract", "none", "extract the downloaded artifact into -d after verification: auto|tar|tar.gz|zip|none")
+var stripComponents = flag.Int("strip-components", 0, "strip N leading path components when extracting, as with tar --strip-components")
+var writeSHA256 = flag.String("write-sha256", "", "write a sha256sum-compatible line for the downloaded file to this path")
+var onUpdate = flag.String("on-update", "", "shell command run after a successful update, with S3REPO_* environment variables set")
+var onNoop = flag.String("on-noop", "", "shell command run when -if-changed finds the remote object unchanged")
+var ifChanged = flag.Bool("if-changed", false, "skip the download and exit 0 if the remote ETag matches the last recorded state")
+
+var Usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s (list|update|promote)\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runHook runs cmd (if non-empty) through the shell, with env merged
+// into the child's environment on top of the current process's own.
+func runHook(cmd string, env map[string]string) error {
+	if cmd == "" {
+		return nil
+	}
+
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = os.Environ()
+	for k, v := range env {
+		c.Env = append(c.Env, k+"="+v)
+	}
+	return c.Run()
+}
+
+// downloadToFile fetches a into destFilePath, resuming from a sibling
+// ".part" file left by a previous interrupted run, and renaming it into
+// place once the transfer completes.
+func downloadToFile(ctx context.Context, repo *s3repo.Repo, a s3repo.Artifact, destFilePath string, onProgress func(written, total int64)) (s3repo.HeadInfo, error) {
+	head, err := repo.Head(ctx, a.Key, a.VersionID)
+	if err != nil {
+		return s3repo.HeadInfo{}, err
+	}
+
+	partPath := destFilePath + ".part"
+	var resumeOffset int64
+	if fi, err := os.Stat(partPath); err == nil {
+		resumeOffset = fi.Size()
+	}
+
+	if resumeOffset > 0 && resumeOffset < head.Size {
+		f, err := os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return head, err
+		}
+		_, err = repo.Download(ctx, a, f, s3repo.DownloadOptions{
+			RangeStart: resumeOffset,
+			IfMatch:    head.ETag,
+			OnProgress: onProgress,
+		})
+		f.Close()
+		if err == nil {
+			return head, os.Rename(partPath, destFilePath)
+		}
+		if !s3repo.IsPreconditionFailed(err) {
+			return head, err
+		}
+
+		// The object changed since the partial download started; the
+		// stale part file can't be trusted, so start over.
+		log.Debugf("object %s changed since partial download began, restarting", a.Key)
+		os.Remove(partPath)
+	}
+
+	f, err := os.Create(partPath)
+	if err != nil {
+		return head, err
+	}
+	_, err = repo.Download(ctx, a, f, s3repo.DownloadOptions{
+		PartSize:    *partSize,
+		Concurrency: *concurrency,
+		OnProgress:  onProgress,
+	})
+	f.Close()
+	if err != nil {
+		return head, err
+	}
+
+	return head, os.Rename(partPath, destFilePath)
+}
+
+// detectArchiveKind maps a key's extension to an -extract=auto kind, or
+// "" if it doesn't look like a supported archive.
+func detectArchiveKind(key string) string {
+	lower := strings.ToLower(key)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	default:
+		return ""
+	}
+}
+
+// stripPathComponents drops the first `strip` slash-separated components
+// of name, mirroring tar --strip-components. ok is false when name has
+// too few components to survive the strip (nothing to extract).
+func stripPathComponents(name string, strip int) (rel string, ok bool) {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	if strip >= len(parts) {
+		return "", false
+	}
+	return filepath.Join(parts[strip:]...), true
+}
+
+// safeJoin joins destDir and rel, and rejects the result if it resolves
+// outside destDir. A tar/zip entry can name a path like "../../etc/passwd",
+// and filepath.Join would otherwise happily escape destDir (zip-slip);
+// this is the one choke point every extracted path goes through.
+func safeJoin(destDir string, rel string) (string, error) {
+	destDir = filepath.Clean(destDir)
+	target := filepath.Join(destDir, rel)
+	inside, err := filepath.Rel(destDir, target)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %q escapes destination directory", rel)
+	}
+	return target, nil
+}
+
+func extractTar(r io.Reader, destDir string, strip int) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, ok := stripPathComponents(hdr.Name, strip)
+		if !ok || rel == "" {
+			continue
+		}
+		target, err := safeJoin(destDir, rel)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			_, copyErr := io.Copy(out, tr)
+			out.Close()
+			if copyErr != nil {
+				return copyErr
+			}
+		}
+	}
+}
+
+func extractZip(archivePath string, destDir string, strip int) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		rel, ok := stripPathComponents(f.Name, strip)
+		if !ok || rel == "" {
+			continue
+		}
+		target, err := safeJoin(destDir, rel)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
+		if err != nil {
+			rc.Close()
+			return err
+		}
+		_, copyErr := io.Copy(out, rc)
+		rc.Close()
+		out.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+	}
+	return nil
+}
+
+// extractArchive unpacks archivePath (of the given kind) into destDir,
+// dropping `strip` leading path components from each entry.
+func extractArchive(archivePath string, destDir string, kind string, strip int) error {
+	switch kind {
+	case "tar":
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return extractTar(f, destDir, strip)
+	case "tar.gz":
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, destDir, strip)
+	case "zip":
+		return extractZip(archivePath, destDir, strip)
+	default:
+		return fmt.Errorf("unsupported -extract kind %q", kind)
+	}
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.Parse()
+
+	logging.SetFormatter(format)
+	if *debug {
+		logging.SetLevel(logging.DEBUG, "s3repo")
+	} else {
+		logging.SetLevel(logging.ERROR, "s3repo")
+	}
+
+	if *service == "" {
+		fmt.Println("No service name provided")
+		os.Exit(1)
+	}
+
+	if *bucket == "" {
+		fmt.Println("No bucket provided")
+		os.Exit(1)
+	}
+
+	if *storeName != "" && *showName {
+		fmt.Println("Cannot use both -n and -p options at the same time")
+		os.Exit(1)
+	}
+
+	if flag.NArg() != 1 {
+		Usage()
+		os.Exit(2)
+	}
+	command := flag.Arg(0)
+
+	if *versionID != "" && command != "update" && command != "promote" {
+		fmt.Println("-version-id can only be used with the update and promote commands")
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	repo, err := s3repo.NewRepo(s3repo.Config{
+		Bucket:      *bucket,
+		Region:      *region,
+		Endpoint:    *endpoint,
+		PathStyle:   *pathStyle,
+		NoVerifySSL: *noVerifySSL,
+		Profile:     *awsProfile,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_pattern := *pattern
+	if _pattern == "" {
+		_pattern = *prefix + ".%W-%B"
+	}
+
+	query := s3repo.Query{
+		Service:  *service,
+		Pattern:  _pattern,
+		Versions: *versions,
+		MaxKeys:  *maxKeys,
+		Since:    *since,
+	}
+
+	log.Debugf("Querying bucket %s for service %s with pattern `%s`", *bucket, *service, _pattern)
+
+	var artifacts []s3repo.Artifact
+	var maxCandidate s3repo.Artifact
+
+	if *channel != "" && (command == "list" || command == "update") {
+		maxCandidate, err = repo.ResolveChannel(ctx, *service, *channel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		artifacts = []s3repo.Artifact{maxCandidate}
+	} else if command == "promote" && *promoteKey != "" {
+		// An explicit -key (optionally pinned to -version-id) fully
+		// specifies what to promote; don't require a successful scan
+		// of the <service>- prefix, which may be empty or may not even
+		// contain the given key (e.g. a brand-new service or a key
+		// outside the usual naming convention).
+	} else {
+		artifacts, maxCandidate, err = repo.ListAndResolve(ctx, query)
+		if err != nil {
+			fmt.Println("No files found to update the service " + *service)
+			os.Exit(1)
+		}
+	}
+
+	if maxCandidate.Key == "" && !(command == "promote" && *promoteKey != "") {
+		fmt.Println("No files found to update the service " + *service)
+		os.Exit(1)
+	}
+
+	if command == "list" {
+		for _, a := range artifacts {
+			marker := " "
+			if a.Key == maxCandidate.Key && a.VersionID == maxCandidate.VersionID {
+				marker = "*"
+			}
+			if *versions {
+				status := ""
+				if a.Deleted {
+					status = " (deleted)"
+				}
+				fmt.Printf("%s%s\t%s\t%s%s\n", marker, a.Key, a.VersionID, a.LastModified, status)
+			} else {
+				fmt.Printf("%s%s\n", marker, a.Key)
+			}
+		}
+	} else if command == "update" {
+		if *verify != "etag" && *verify != "sha256" && *verify != "both" && *verify != "none" {
+			fmt.Println("-verify must be one of etag|sha256|both|none")
+			os.Exit(1)
+		}
+
+		wantedVersionID := maxCandidate.VersionID
+		if *versionID != "" {
+			wantedVersionID = *versionID
+		}
+		downloadArtifact := maxCandidate
+		downloadArtifact.VersionID = wantedVersionID
+
+		if *destination == "" {
+			fmt.Println("Destination file not provided")
+			os.Exit(1)
+		}
+
+		if err := os.MkdirAll(*destination, 0755|os.ModeDir); err != nil {
+			log.Fatal(err)
+		}
+
+		destFilePath := path.Join(*destination, maxCandidate.Key)
+		stateFilePath := destFilePath + ".etag"
+
+		hookEnv := map[string]string{
+			"S3REPO_KEY":        maxCandidate.Key,
+			"S3REPO_PATH":       destFilePath,
+			"S3REPO_BUILD":      strconv.Itoa(maxCandidate.Build),
+			"S3REPO_VERSION_ID": wantedVersionID,
+		}
+
+		if *ifChanged {
+			head, err := repo.Head(ctx, maxCandidate.Key, wantedVersionID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if prev, err := ioutil.ReadFile(stateFilePath); err == nil && strings.TrimSpace(string(prev)) == head.ETag {
+				log.Debugf("remote ETag for %s unchanged, skipping download", maxCandidate.Key)
+				if err := runHook(*onNoop, hookEnv); err != nil {
+					log.Fatal(err)
+				}
+				os.Exit(0)
+			}
+		}
+
+		var onProgress func(written, total int64)
+		if *showProgress {
+			onProgress = func(written, total int64) {
+				fmt.Printf("\r%s: %3d%%", *service, written*100/total)
+			}
+		}
+
+		head, err := downloadToFile(ctx, repo, downloadArtifact, destFilePath, onProgress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *showProgress {
+			fmt.Println()
+		}
+
+		if *verify != "none" {
+			if err := s3repo.VerifyDownload(destFilePath, head, *verify); err != nil {
+				os.Remove(destFilePath)
+				log.Fatal(err)
+			}
+		}
+
+		if *extract != "none" {
+			kind := *extract
+			if kind == "auto" {
+				kind = detectArchiveKind(maxCandidate.Key)
+				if kind == "" {
+					log.Fatal(fmt.Errorf("cannot auto-detect archive type for %s", maxCandidate.Key))
+				}
+			}
+			if err := extractArchive(destFilePath, *destination, kind, *stripComponents); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *writeSHA256 != "" {
+			sum, err := s3repo.SHA256OfFile(destFilePath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			line := fmt.Sprintf("%s  %s\n", sum, path.Base(destFilePath))
+			if err := ioutil.WriteFile(*writeSHA256, []byte(line), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *showName {
+			fmt.Println(destFilePath)
+		}
+
+		if *storeName != "" {
+			line := destFilePath
+			if wantedVersionID != "" {
+				line = fmt.Sprintf("%s\t%s", destFilePath, wantedVersionID)
+			}
+			if err := ioutil.WriteFile(*storeName, []byte(line), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := ioutil.WriteFile(stateFilePath, []byte(head.ETag), 0644); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := runHook(*onUpdate, hookEnv); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "promote" {
+		a := maxCandidate
+		if *promoteKey != "" {
+			a = s3repo.Artifact{Key: *promoteKey, VersionID: *versionID}
+
+			matches, err := repo.List(ctx, query)
+			if err == nil {
+				for _, m := range matches {
+					if m.Key == *promoteKey {
+						a.Build = m.Build
+						break
+					}
+				}
+			}
+		}
+
+		if err := repo.Promote(ctx, *service, *channel, a, *writeManifest, *tagObject); err != nil {
+			log.Fatal(err)
+		}
+
+		channelName := *channel
+		if channelName == "" {
+			channelName = "stable"
+		}
+		fmt.Printf("Promoted %s (build %d) to channel %s\n", a.Key, a.Build, channelName)
+	}
+}
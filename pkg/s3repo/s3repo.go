@@ -0,0 +1,843 @@
+// Package s3repo resolves and fetches versioned build artifacts stored
+// in an S3 (or S3-compatible) bucket under a "<service>-<version>"
+// naming convention. It is the library underneath the s3repo command;
+// see cmd/s3repo for the CLI.
+package s3repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Version pattern:
+//   %V - single decimal integer version
+//   %S - alphanumeric (+period) subversion
+//   %G - optional git commit distance (-{NUMBER}-g{HASH})
+//   %B - number in the build sequence
+//   %W - any text
+
+func getKeyRegexp(service string, pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(service + "-" + pattern)
+	constructs := make(map[string]string)
+	constructs["%V"] = "([0-9]+)"
+	constructs["%S"] = "([0-9a-zA-Z.,]+)"
+	constructs["%G"] = "(-[0-9]+-g[0-9a-z]+)?"
+	constructs["%B"] = "(?P<buildnum>[0-9]+)"
+	constructs["%W"] = "(.*)"
+
+	var expr string = quoted
+	for k, v := range constructs {
+		expr = strings.Replace(expr, k, v, -1)
+	}
+
+	return regexp.Compile("^" + expr + "$")
+}
+
+// Artifact is a single build artifact resolved from a bucket: either a
+// plain object or one historical version of it.
+type Artifact struct {
+	Key          string
+	Build        int
+	VersionID    string
+	LastModified time.Time
+	Size         int64
+	ETag         string
+	Deleted      bool
+}
+
+// Query selects which artifacts of a service to look at.
+type Query struct {
+	Service string
+	Pattern string // version pattern; see getKeyRegexp. Empty uses Prefix+".%W-%B".
+	Prefix  string // legacy version prefix, used when Pattern is empty
+
+	Versions bool          // list/resolve against all object versions, including deleted ones
+	MaxKeys  int64         // stop scanning after this many keys (0 = unlimited)
+	Since    time.Duration // only consider objects modified within this duration (0 = no limit)
+}
+
+func (q Query) keyRegexp() (*regexp.Regexp, error) {
+	pattern := q.Pattern
+	if pattern == "" {
+		prefix := q.Prefix
+		if prefix == "" {
+			prefix = "0.1."
+		}
+		pattern = prefix + ".%W-%B"
+	}
+	return getKeyRegexp(q.Service, pattern)
+}
+
+// object is a single bucket entry (or object version) as returned by a
+// backend, before it's been matched against a Query's pattern.
+type object struct {
+	Key          string
+	VersionID    string
+	LastModified *time.Time
+	Size         int64
+	ETag         string
+	Deleted      bool
+}
+
+func (o object) lastModified() time.Time {
+	if o.LastModified == nil {
+		return time.Time{}
+	}
+	return *o.LastModified
+}
+
+// listOptions bounds and filters a backend.List call.
+type listOptions struct {
+	versions bool
+	maxKeys  int64
+	since    time.Duration
+}
+
+// HeadInfo is the metadata needed to verify a downloaded artifact
+// without re-fetching its body.
+type HeadInfo struct {
+	Size          int64
+	ETag          string // unquoted; contains a dash for multipart uploads
+	SHA256        string // from the x-amz-meta-sha256 object metadata, if set
+	PartsCount    int64  // multipart uploads only
+	FirstPartSize int64  // multipart uploads only; size of part 1
+}
+
+// DownloadOptions configures Repo.Download.
+type DownloadOptions struct {
+	PartSize    int64 // part size for concurrent multipart downloads (0 = SDK default)
+	Concurrency int   // concurrent parts in flight (0 = SDK default)
+
+	// RangeStart resumes a download from this byte offset; w is expected
+	// to already hold the bytes before RangeStart (e.g. an append-mode
+	// file) and IfMatch guards against the object changing in the
+	// meantime. Concurrency/PartSize are ignored when set.
+	RangeStart int64
+	IfMatch    string
+
+	OnProgress func(written, total int64)
+}
+
+// backend abstracts the storage operations s3repo needs, so that Repo
+// doesn't depend on the AWS SDK directly. s3Backend is the only
+// implementation today; a MinIO/Ceph/LocalStack endpoint is reached by
+// pointing it at a custom Config.Endpoint, and a fake backend can stand
+// in for tests.
+type backend interface {
+	// List streams every object under prefix to visit, in page order,
+	// stopping early if visit returns false. It does not accumulate
+	// the listing in memory, so it stays bounded on buckets with very
+	// large numbers of keys under the service prefix.
+	List(ctx context.Context, prefix string, opts listOptions, visit func(object) bool) error
+	Head(ctx context.Context, key string, versionID string) (HeadInfo, error)
+	Download(ctx context.Context, key string, versionID string, w io.Writer, opts DownloadOptions) (HeadInfo, error)
+	Put(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	Tag(ctx context.Context, key string, versionID string, tags map[string]string) error
+}
+
+// Config describes how to reach the bucket a Repo serves.
+type Config struct {
+	Bucket string
+	Region string // defaults to "us-east-1"
+
+	Endpoint    string // S3-compatible endpoint URL (MinIO, Ceph RGW, LocalStack, ...); empty uses AWS
+	PathStyle   bool   // use path-style bucket addressing, as required by most non-AWS endpoints
+	NoVerifySSL bool   // skip TLS certificate verification when talking to Endpoint
+	Profile     string // AWS credentials profile; empty uses environment credentials
+}
+
+// Repo resolves and fetches build artifacts from a single bucket.
+type Repo struct {
+	backend backend
+	bucket  string
+}
+
+// NewRepo builds a Repo backed by the AWS SDK (or an S3-compatible
+// endpoint, per cfg).
+func NewRepo(cfg Config) (*Repo, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3repo: Config.Bucket is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	awsCfg := aws.NewConfig().WithRegion(region)
+
+	if cfg.Profile != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewSharedCredentials("", cfg.Profile))
+	} else {
+		awsCfg = awsCfg.WithCredentials(credentials.NewEnvCredentials())
+	}
+
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.PathStyle {
+		awsCfg = awsCfg.WithS3ForcePathStyle(true)
+	}
+	if cfg.NoVerifySSL {
+		awsCfg = awsCfg.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		})
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{backend: &s3Backend{svc: s3.New(sess), bucket: cfg.Bucket}, bucket: cfg.Bucket}, nil
+}
+
+// matchBuild matches key (minus its extension) against pattern, and
+// extracts its build number from the "buildnum" capture group. ok is
+// false when key doesn't match, or matches but its build number isn't a
+// valid integer. A match with an empty build number (a pattern without
+// %B) yields build 0 and ok true.
+func matchBuild(pattern *regexp.Regexp, key string) (build int, ok bool) {
+	ext := path.Ext(key)
+	basename := strings.TrimSuffix(key, ext)
+
+	if !pattern.MatchString(basename) {
+		return 0, false
+	}
+
+	buildStr := pattern.ReplaceAllString(basename, "${buildnum}")
+	if buildStr == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(buildStr)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func toArtifact(o object, build int) Artifact {
+	return Artifact{
+		Key:          o.Key,
+		Build:        build,
+		VersionID:    o.VersionID,
+		LastModified: o.lastModified(),
+		Size:         o.Size,
+		ETag:         o.ETag,
+		Deleted:      o.Deleted,
+	}
+}
+
+// scan accumulates the running result of a bucket listing through a
+// single streamed pass: every pattern-compatible artifact (when
+// collectArtifacts is set), the highest-build match among them, and the
+// most recently modified object regardless of whether it matched. Since
+// it's fed directly from a backend.List callback, none of List,
+// Resolve, or ListAndResolve ever hold the full listing in memory.
+type scan struct {
+	pattern          *regexp.Regexp
+	collectArtifacts bool
+
+	artifacts []Artifact
+	best      Artifact
+	haveBest  bool
+
+	mostRecent     Artifact
+	mostRecentTime time.Time
+	haveMostRecent bool
+}
+
+func (s *scan) visit(o object) bool {
+	if build, ok := matchBuild(s.pattern, o.Key); ok {
+		a := toArtifact(o, build)
+		if s.collectArtifacts {
+			s.artifacts = append(s.artifacts, a)
+		}
+		if !s.haveBest || a.Build > s.best.Build {
+			s.best = a
+			s.haveBest = true
+		}
+	}
+
+	lm := o.lastModified()
+	if !s.haveMostRecent || lm.After(s.mostRecentTime) {
+		s.mostRecentTime = lm
+		s.mostRecent = toArtifact(o, 0)
+		s.haveMostRecent = true
+	}
+	return true
+}
+
+// List returns every artifact under q.Service's prefix whose key matches
+// q.Pattern (or the legacy q.Prefix form), each tagged with its parsed
+// build number.
+func (r *Repo) List(ctx context.Context, q Query) ([]Artifact, error) {
+	pattern, err := q.keyRegexp()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &scan{pattern: pattern, collectArtifacts: true}
+	if err := r.backend.List(ctx, q.Service+"-", listOptions{versions: q.Versions, maxKeys: q.MaxKeys, since: q.Since}, s.visit); err != nil {
+		return nil, err
+	}
+	return s.artifacts, nil
+}
+
+// Resolve returns the highest-build artifact matching q, falling back to
+// the most recently modified object under q.Service's prefix when
+// nothing matches q.Pattern.
+func (r *Repo) Resolve(ctx context.Context, q Query) (Artifact, error) {
+	pattern, err := q.keyRegexp()
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	s := &scan{pattern: pattern}
+	if err := r.backend.List(ctx, q.Service+"-", listOptions{versions: q.Versions, maxKeys: q.MaxKeys, since: q.Since}, s.visit); err != nil {
+		return Artifact{}, err
+	}
+
+	if s.haveBest {
+		return s.best, nil
+	}
+	if s.haveMostRecent {
+		return s.mostRecent, nil
+	}
+	return Artifact{}, fmt.Errorf("s3repo: no artifacts found for service %q", q.Service)
+}
+
+// ListAndResolve is equivalent to calling List and Resolve with the same
+// q, but scans the bucket once instead of twice: List and Resolve both
+// need the same backend.List results, just summarized differently.
+func (r *Repo) ListAndResolve(ctx context.Context, q Query) ([]Artifact, Artifact, error) {
+	pattern, err := q.keyRegexp()
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	s := &scan{pattern: pattern, collectArtifacts: true}
+	if err := r.backend.List(ctx, q.Service+"-", listOptions{versions: q.Versions, maxKeys: q.MaxKeys, since: q.Since}, s.visit); err != nil {
+		return nil, Artifact{}, err
+	}
+
+	if s.haveBest {
+		return s.artifacts, s.best, nil
+	}
+	if s.haveMostRecent {
+		return s.artifacts, s.mostRecent, nil
+	}
+	return s.artifacts, Artifact{}, fmt.Errorf("s3repo: no artifacts found for service %q", q.Service)
+}
+
+// Head returns metadata for key (pinned to versionID when non-empty)
+// without fetching its body.
+func (r *Repo) Head(ctx context.Context, key string, versionID string) (HeadInfo, error) {
+	return r.backend.Head(ctx, key, versionID)
+}
+
+// Download fetches key (pinned to versionID when non-empty) into w,
+// returning the same metadata Head would so the caller can verify the
+// result without a second round trip. See DownloadOptions for resumable
+// and concurrent-part behavior.
+func (r *Repo) Download(ctx context.Context, a Artifact, w io.Writer, opts DownloadOptions) (HeadInfo, error) {
+	return r.backend.Download(ctx, a.Key, a.VersionID, w, opts)
+}
+
+// PutManifest uploads a small object such as a release-channel manifest.
+func (r *Repo) PutManifest(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error {
+	return r.backend.Put(ctx, key, body, size, contentType)
+}
+
+// GetManifest fully reads a small object such as a release-channel
+// manifest.
+func (r *Repo) GetManifest(ctx context.Context, key string) ([]byte, error) {
+	return r.backend.GetBytes(ctx, key)
+}
+
+// Tag replaces key's (optionally version-pinned) object tag set.
+func (r *Repo) Tag(ctx context.Context, key string, versionID string, tags map[string]string) error {
+	return r.backend.Tag(ctx, key, versionID, tags)
+}
+
+// ManifestDoc is the small JSON document Promote writes to
+// ManifestKey(service, channel), letting clients resolve a release
+// channel with a single GetObject instead of scanning the whole bucket.
+type ManifestDoc struct {
+	Key        string    `json:"key"`
+	VersionID  string    `json:"versionId,omitempty"`
+	Buildnum   int       `json:"buildnum"`
+	SHA256     string    `json:"sha256,omitempty"`
+	PromotedAt time.Time `json:"promotedAt"`
+}
+
+// ManifestKey returns the object key a release channel's manifest is
+// stored at.
+func ManifestKey(service string, channel string) string {
+	return fmt.Sprintf("%s/%s.json", service, channel)
+}
+
+// ResolveChannel resolves the artifact currently promoted to channel for
+// service, via a single GetObject of its manifest.
+func (r *Repo) ResolveChannel(ctx context.Context, service string, channel string) (Artifact, error) {
+	data, err := r.GetManifest(ctx, ManifestKey(service, channel))
+	if err != nil {
+		return Artifact{}, err
+	}
+	var m ManifestDoc
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Key: m.Key, VersionID: m.VersionID, Build: m.Buildnum}, nil
+}
+
+// Promote marks a as the current release for channel (defaulting to
+// "stable"), writing a manifest and/or setting the s3repo-channel object
+// tag per writeManifest/tagObject.
+func (r *Repo) Promote(ctx context.Context, service string, channel string, a Artifact, writeManifest bool, tagObject bool) error {
+	channelName := channel
+	if channelName == "" {
+		channelName = "stable"
+	}
+
+	var sha256Hex string
+	if head, err := r.Head(ctx, a.Key, a.VersionID); err == nil {
+		sha256Hex = head.SHA256
+	}
+
+	if writeManifest {
+		m := ManifestDoc{
+			Key:        a.Key,
+			VersionID:  a.VersionID,
+			Buildnum:   a.Build,
+			SHA256:     sha256Hex,
+			PromotedAt: time.Now(),
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := r.PutManifest(ctx, ManifestKey(service, channelName), bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+			return err
+		}
+	}
+
+	if tagObject {
+		if err := r.Tag(ctx, a.Key, a.VersionID, map[string]string{"s3repo-channel": channelName}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type s3Backend struct {
+	svc    *s3.S3
+	bucket string
+}
+
+func (b *s3Backend) List(ctx context.Context, prefix string, opts listOptions, visit func(object) bool) error {
+	var sinceCutoff *time.Time
+	if opts.since > 0 {
+		cutoff := time.Now().Add(-opts.since)
+		sinceCutoff = &cutoff
+	}
+
+	var pageMaxKeys *int64
+	if opts.maxKeys > 0 {
+		pageMaxKeys = aws.Int64(opts.maxKeys)
+	}
+
+	var keysSeen int64
+	// consider feeds a single object through the --since filter and on
+	// to visit as pages arrive, so a match is never held in memory
+	// longer than it takes the caller's visit to process it. keysSeen
+	// counts every object the pager hands us, not just the ones that
+	// pass --since, so --max-keys bounds the scan itself rather than
+	// just the number of matches that happen to be recent enough.
+	consider := func(o object) bool {
+		keysSeen++
+		if sinceCutoff == nil || (o.LastModified != nil && !o.LastModified.Before(*sinceCutoff)) {
+			if !visit(o) {
+				return false
+			}
+		}
+		return opts.maxKeys <= 0 || keysSeen < opts.maxKeys
+	}
+
+	if opts.versions {
+		return b.svc.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+			Bucket:  aws.String(b.bucket),
+			Prefix:  aws.String(prefix),
+			MaxKeys: pageMaxKeys,
+		}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, v := range page.Versions {
+				o := object{Key: *v.Key, VersionID: *v.VersionId, LastModified: v.LastModified, Size: aws.Int64Value(v.Size)}
+				if v.ETag != nil {
+					o.ETag = strings.Trim(*v.ETag, "\"")
+				}
+				if !consider(o) {
+					return false
+				}
+			}
+			for _, d := range page.DeleteMarkers {
+				if !consider(object{Key: *d.Key, VersionID: *d.VersionId, LastModified: d.LastModified, Deleted: true}) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	return b.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: pageMaxKeys,
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			entry := object{Key: *o.Key, LastModified: o.LastModified, Size: aws.Int64Value(o.Size)}
+			if o.ETag != nil {
+				entry.ETag = strings.Trim(*o.ETag, "\"")
+			}
+			if !consider(entry) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (b *s3Backend) Head(ctx context.Context, key string, versionID string) (HeadInfo, error) {
+	input := &s3.HeadObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	resp, err := b.svc.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		return HeadInfo{}, err
+	}
+
+	info := HeadInfo{Size: aws.Int64Value(resp.ContentLength)}
+	if resp.ETag != nil {
+		info.ETag = strings.Trim(*resp.ETag, "\"")
+	}
+	if sha, ok := resp.Metadata["Sha256"]; ok && sha != nil {
+		info.SHA256 = *sha
+	}
+
+	if strings.Contains(info.ETag, "-") {
+		// Multipart upload: ask for part 1 specifically, which reports
+		// that part's size and the total part count, so a local copy
+		// can be re-chunked the same way to recompute the ETag.
+		partInput := &s3.HeadObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key), PartNumber: aws.Int64(1)}
+		if versionID != "" {
+			partInput.VersionId = aws.String(versionID)
+		}
+		if partResp, err := b.svc.HeadObjectWithContext(ctx, partInput); err == nil {
+			info.FirstPartSize = aws.Int64Value(partResp.ContentLength)
+			info.PartsCount = aws.Int64Value(partResp.PartsCount)
+		}
+	}
+
+	return info, nil
+}
+
+// IsPreconditionFailed reports whether err is the AWS "PreconditionFailed"
+// error returned when a ranged GetObject's If-Match guard doesn't match
+// the object's current ETag — the signal that a resumed download's
+// partial file is stale and must be restarted from scratch.
+func IsPreconditionFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "PreconditionFailed"
+	}
+	return false
+}
+
+// progressWriterAt wraps a WriterAt with a byte counter so concurrent
+// s3manager part writers can drive a single progress callback.
+type progressWriterAt struct {
+	w          io.WriterAt
+	written    int64
+	total      int64
+	onProgress func(written, total int64)
+}
+
+func (p *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.w.WriteAt(b, off)
+	if n > 0 {
+		p.onProgress(atomic.AddInt64(&p.written, int64(n)), p.total)
+	}
+	return n, err
+}
+
+// progressReader wraps an io.Reader, calling onRead after every Read.
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.onRead(n)
+	}
+	return n, err
+}
+
+func (b *s3Backend) Download(ctx context.Context, key string, versionID string, w io.Writer, opts DownloadOptions) (HeadInfo, error) {
+	head, err := b.Head(ctx, key, versionID)
+	if err != nil {
+		return HeadInfo{}, err
+	}
+
+	if opts.RangeStart > 0 {
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", opts.RangeStart)),
+		}
+		if opts.IfMatch != "" {
+			input.IfMatch = aws.String(opts.IfMatch)
+		}
+		if versionID != "" {
+			input.VersionId = aws.String(versionID)
+		}
+
+		resp, err := b.svc.GetObjectWithContext(ctx, input)
+		if err != nil {
+			return head, err
+		}
+		defer resp.Body.Close()
+
+		var r io.Reader = resp.Body
+		if opts.OnProgress != nil {
+			written := opts.RangeStart
+			r = &progressReader{r: resp.Body, onRead: func(n int) {
+				written += int64(n)
+				opts.OnProgress(written, head.Size)
+			}}
+		}
+
+		_, err = io.Copy(w, r)
+		return head, err
+	}
+
+	if writerAt, ok := w.(io.WriterAt); ok {
+		downloader := s3manager.NewDownloaderWithClient(b.svc, func(d *s3manager.Downloader) {
+			if opts.PartSize > 0 {
+				d.PartSize = opts.PartSize
+			}
+			if opts.Concurrency > 0 {
+				d.Concurrency = opts.Concurrency
+			}
+		})
+
+		var dst io.WriterAt = writerAt
+		if opts.OnProgress != nil {
+			dst = &progressWriterAt{w: writerAt, total: head.Size, onProgress: opts.OnProgress}
+		}
+
+		getInput := &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)}
+		if versionID != "" {
+			getInput.VersionId = aws.String(versionID)
+		}
+
+		_, err := downloader.DownloadWithContext(ctx, dst, getInput)
+		return head, err
+	}
+
+	input := &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	resp, err := b.svc.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return head, err
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if opts.OnProgress != nil {
+		var written int64
+		r = &progressReader{r: resp.Body, onRead: func(n int) {
+			written += int64(n)
+			opts.OnProgress(written, head.Size)
+		}}
+	}
+
+	_, err = io.Copy(w, r)
+	return head, err
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := b.svc.PutObjectWithContext(ctx, input)
+	return err
+}
+
+func (b *s3Backend) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (b *s3Backend) Tag(ctx context.Context, key string, versionID string, tags map[string]string) error {
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	input := &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(b.bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	_, err := b.svc.PutObjectTaggingWithContext(ctx, input)
+	return err
+}
+
+func md5OfFile(r io.Reader) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyETag recomputes path's ETag and compares it against head.ETag,
+// handling the multipart case (a dash-suffixed ETag is the hex MD5 of
+// the concatenated per-part MD5s, followed by "-<part count>").
+func VerifyETag(path string, head HeadInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !strings.Contains(head.ETag, "-") {
+		sum, err := md5OfFile(f)
+		if err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(sum); got != head.ETag {
+			return fmt.Errorf("ETag mismatch for %s: expected %s, got %s", path, head.ETag, got)
+		}
+		return nil
+	}
+
+	if head.PartsCount == 0 || head.FirstPartSize == 0 {
+		return fmt.Errorf("cannot verify multipart ETag for %s: HeadObject did not report part size/count", path)
+	}
+
+	var partSums []byte
+	for part := int64(0); part < head.PartsCount; part++ {
+		n := head.FirstPartSize
+		if part == head.PartsCount-1 {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			n = fi.Size() - part*head.FirstPartSize
+		}
+		sum, err := md5OfFile(io.LimitReader(f, n))
+		if err != nil {
+			return err
+		}
+		partSums = append(partSums, sum...)
+	}
+
+	digest := md5.Sum(partSums)
+	got := fmt.Sprintf("%s-%d", hex.EncodeToString(digest[:]), head.PartsCount)
+	if got != head.ETag {
+		return fmt.Errorf("multipart ETag mismatch for %s: expected %s, got %s", path, head.ETag, got)
+	}
+	return nil
+}
+
+// SHA256OfFile returns the hex SHA256 digest of the file at path.
+func SHA256OfFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifySHA256 compares path's SHA256 digest against expected (hex).
+func VerifySHA256(path string, expected string) error {
+	got, err := SHA256OfFile(path)
+	if err != nil {
+		return err
+	}
+	if got != expected {
+		return fmt.Errorf("SHA256 mismatch for %s: expected %s, got %s", path, expected, got)
+	}
+	return nil
+}
+
+// VerifyDownload checks path against head according to mode
+// (etag|sha256|both|none). A check whose value head lacks (an empty
+// ETag or SHA256) is skipped.
+func VerifyDownload(path string, head HeadInfo, mode string) error {
+	if (mode == "etag" || mode == "both") && head.ETag != "" {
+		if err := VerifyETag(path, head); err != nil {
+			return err
+		}
+	}
+	if (mode == "sha256" || mode == "both") && head.SHA256 != "" {
+		if err := VerifySHA256(path, head.SHA256); err != nil {
+			return err
+		}
+	}
+	return nil
+}
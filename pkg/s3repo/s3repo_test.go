@@ -0,0 +1,245 @@
+package s3repo
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackend is an in-memory backend implementation, standing in for
+// s3Backend so Repo's matching/fallback/manifest logic can be tested
+// without a real bucket.
+type fakeBackend struct {
+	objects []object
+
+	heads map[string]HeadInfo
+
+	putCalls []fakePutCall
+	tagCalls []fakeTagCall
+}
+
+type fakePutCall struct {
+	key  string
+	body []byte
+}
+
+type fakeTagCall struct {
+	key       string
+	versionID string
+	tags      map[string]string
+}
+
+func (f *fakeBackend) List(ctx context.Context, prefix string, opts listOptions, visit func(object) bool) error {
+	for _, o := range f.objects {
+		if !strings.HasPrefix(o.Key, prefix) {
+			continue
+		}
+		if !visit(o) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeBackend) Head(ctx context.Context, key string, versionID string) (HeadInfo, error) {
+	if h, ok := f.heads[key]; ok {
+		return h, nil
+	}
+	return HeadInfo{}, os.ErrNotExist
+}
+
+func (f *fakeBackend) Download(ctx context.Context, key string, versionID string, w io.Writer, opts DownloadOptions) (HeadInfo, error) {
+	return HeadInfo{}, os.ErrNotExist
+}
+
+func (f *fakeBackend) Put(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	f.putCalls = append(f.putCalls, fakePutCall{key: key, body: data})
+	return nil
+}
+
+func (f *fakeBackend) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	for _, c := range f.putCalls {
+		if c.key == key {
+			return c.body, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (f *fakeBackend) Tag(ctx context.Context, key string, versionID string, tags map[string]string) error {
+	f.tagCalls = append(f.tagCalls, fakeTagCall{key: key, versionID: versionID, tags: tags})
+	return nil
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func TestRepoList(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend := &fakeBackend{objects: []object{
+		{Key: "foo-5.bin", LastModified: timePtr(t0), Size: 42},
+		{Key: "foo-12.bin", LastModified: timePtr(t0.Add(time.Hour)), Size: 99},
+		{Key: "foo-abc.bin", LastModified: timePtr(t0)}, // doesn't match %B
+		{Key: "bar-99.bin", LastModified: timePtr(t0)},  // different service prefix
+	}}
+	repo := &Repo{backend: backend, bucket: "test"}
+
+	artifacts, err := repo.List(context.Background(), Query{Service: "foo", Pattern: "%B"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(artifacts) != 2 {
+		t.Fatalf("expected 2 matching artifacts, got %d: %+v", len(artifacts), artifacts)
+	}
+	for _, a := range artifacts {
+		if strings.HasPrefix(a.Key, "bar-") {
+			t.Fatalf("List returned an artifact outside the service prefix: %+v", a)
+		}
+		if a.Key == "foo-5.bin" && a.Size != 42 {
+			t.Fatalf("expected Size to be carried through from the listing, got %+v", a)
+		}
+	}
+}
+
+func TestRepoResolvePicksHighestBuild(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend := &fakeBackend{objects: []object{
+		{Key: "foo-5.bin", LastModified: timePtr(t0)},
+		{Key: "foo-12.bin", LastModified: timePtr(t0)},
+		{Key: "foo-7.bin", LastModified: timePtr(t0)},
+	}}
+	repo := &Repo{backend: backend, bucket: "test"}
+
+	best, err := repo.Resolve(context.Background(), Query{Service: "foo", Pattern: "%B"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if best.Key != "foo-12.bin" || best.Build != 12 {
+		t.Fatalf("expected foo-12.bin (build 12), got %+v", best)
+	}
+}
+
+func TestRepoResolveFallsBackToMostRecent(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend := &fakeBackend{objects: []object{
+		{Key: "foo-release.txt", LastModified: timePtr(t0)},
+		{Key: "foo-notes.txt", LastModified: timePtr(t0.Add(time.Hour))},
+	}}
+	repo := &Repo{backend: backend, bucket: "test"}
+
+	// Pattern matches nothing under the foo- prefix, so Resolve must
+	// fall back to the most recently modified object.
+	best, err := repo.Resolve(context.Background(), Query{Service: "foo", Pattern: "nomatch-%B"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if best.Key != "foo-notes.txt" {
+		t.Fatalf("expected fallback to the most recently modified object, got %+v", best)
+	}
+}
+
+func TestRepoResolveNoObjects(t *testing.T) {
+	repo := &Repo{backend: &fakeBackend{}, bucket: "test"}
+
+	if _, err := repo.Resolve(context.Background(), Query{Service: "foo", Pattern: "%B"}); err == nil {
+		t.Fatal("expected an error when the service prefix has no objects at all")
+	}
+}
+
+func TestVerifyETagSinglePart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact.bin")
+	content := []byte("hello world, this is a test artifact")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := md5.Sum(content)
+	etag := hex.EncodeToString(sum[:])
+
+	if err := VerifyETag(path, HeadInfo{ETag: etag}); err != nil {
+		t.Fatalf("VerifyETag: %v", err)
+	}
+	if err := VerifyETag(path, HeadInfo{ETag: "deadbeef"}); err == nil {
+		t.Fatal("expected a mismatch error for a bogus ETag")
+	}
+}
+
+func TestVerifyETagMultipart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact.bin")
+
+	partSize := int64(16)
+	part1 := []byte("0123456789abcdef")
+	part2 := []byte("ZZZ")
+	content := append(append([]byte{}, part1...), part2...)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum1 := md5.Sum(part1)
+	sum2 := md5.Sum(part2)
+	digest := md5.Sum(append(append([]byte{}, sum1[:]...), sum2[:]...))
+	etag := hex.EncodeToString(digest[:]) + "-2"
+
+	head := HeadInfo{ETag: etag, PartsCount: 2, FirstPartSize: partSize}
+	if err := VerifyETag(path, head); err != nil {
+		t.Fatalf("VerifyETag: %v", err)
+	}
+
+	head.ETag = hex.EncodeToString(digest[:]) + "-3"
+	if err := VerifyETag(path, head); err == nil {
+		t.Fatal("expected a mismatch error for a bogus part count")
+	}
+}
+
+func TestPromoteWritesManifestAndTag(t *testing.T) {
+	backend := &fakeBackend{
+		heads: map[string]HeadInfo{"foo-12.bin": {SHA256: "abc123"}},
+	}
+	repo := &Repo{backend: backend, bucket: "test"}
+
+	a := Artifact{Key: "foo-12.bin", Build: 12, VersionID: "v1"}
+	if err := repo.Promote(context.Background(), "foo", "stable", a, true, true); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+
+	if len(backend.tagCalls) != 1 {
+		t.Fatalf("expected one Tag call, got %d", len(backend.tagCalls))
+	}
+	tc := backend.tagCalls[0]
+	if tc.key != a.Key || tc.versionID != a.VersionID || tc.tags["s3repo-channel"] != "stable" {
+		t.Fatalf("unexpected Tag call: %+v", tc)
+	}
+
+	resolved, err := repo.ResolveChannel(context.Background(), "foo", "stable")
+	if err != nil {
+		t.Fatalf("ResolveChannel: %v", err)
+	}
+	if resolved.Key != a.Key || resolved.VersionID != a.VersionID || resolved.Build != a.Build {
+		t.Fatalf("manifest round trip mismatch: got %+v, want Key/VersionID/Build of %+v", resolved, a)
+	}
+
+	data, err := backend.GetBytes(context.Background(), ManifestKey("foo", "stable"))
+	if err != nil {
+		t.Fatalf("GetBytes: %v", err)
+	}
+	var m ManifestDoc
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v", err)
+	}
+	if m.SHA256 != "abc123" {
+		t.Fatalf("expected manifest to carry the SHA256 from Head, got %q", m.SHA256)
+	}
+}